@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// bulkGet handles POST /bulk/get, returning the stored tree URLs for each
+// requested SHA1, or null for a SHA1 with no stored record.
+func (t *TreeURLs) bulkGet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SHA1s []string `json:"sha1s"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	for _, sha1 := range req.SHA1s {
+		valid, err := validSHA1(sha1)
+		if err != nil {
+			errored(w, err.Error())
+			return
+		}
+		if !valid {
+			badRequest(w, fmt.Sprintf("'%s' is not a valid SHA1", sha1))
+			return
+		}
+	}
+
+	found, err := t.db.GetTreeURLsBatch(req.SHA1s)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	results := make(map[string]interface{}, len(req.SHA1s))
+	for _, sha1 := range req.SHA1s {
+		if treeURLs, ok := found[sha1]; ok {
+			results[sha1] = json.RawMessage(treeURLs)
+		} else {
+			results[sha1] = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// bulkPut handles POST /bulk/put, inserting or updating every sha1/tree
+// URLs pair in the request body as a single atomic batch.
+func (t *TreeURLs) bulkPut(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	treeURLs := make(map[string]string, len(req))
+	for sha1, raw := range req {
+		valid, err := validSHA1(sha1)
+		if err != nil {
+			errored(w, err.Error())
+			return
+		}
+		if !valid {
+			badRequest(w, fmt.Sprintf("'%s' is not a valid SHA1", sha1))
+			return
+		}
+		treeURLs[sha1] = string(raw)
+	}
+
+	if err := t.db.UpsertTreeURLsBatch(treeURLs); err != nil {
+		errored(w, err.Error())
+		return
+	}
+	for sha1, urls := range treeURLs {
+		t.appendLogLeaf("put", sha1, urls)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBulkPutInsert(t *testing.T) {
+	sha1a := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	sha1b := "0000000000000000000000000000000000000a"
+	body := fmt.Sprintf(`{"%s":[{"label":"a"}],"%s":[{"label":"b"}]}`, sha1a, sha1b)
+
+	mock := NewMockDB()
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/bulk/put", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status was %d instead of %d", res.StatusCode, http.StatusOK)
+	}
+
+	for _, sha1 := range []string{sha1a, sha1b} {
+		has, err := mock.HasSHA1(sha1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Errorf("'%s' was not stored after the bulk put", sha1)
+		}
+	}
+}
+
+func TestBulkPutUpdate(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	original := `[{"label":"original"}]`
+	updated := `[{"label":"updated"}]`
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(sha1, original); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	body := fmt.Sprintf(`{"%s":%s}`, sha1, updated)
+	res, err := http.Post(server.URL+"/bulk/put", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status was %d instead of %d", res.StatusCode, http.StatusOK)
+	}
+
+	records, err := mock.GetTreeURLs(sha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0] != updated {
+		t.Errorf("tree URLs were '%s' instead of '%s'", records[0], updated)
+	}
+}
+
+func TestBulkPutInvalidSHA1(t *testing.T) {
+	mock := NewMockDB()
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	body := `{"not-a-sha1!":[{"label":"a"}]}`
+	res, err := http.Post(server.URL+"/bulk/put", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestBulkGetMixedHitMiss(t *testing.T) {
+	stored := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	missing := "0000000000000000000000000000000000000a"
+	treeURL := `[{"label":"tree_0"}]`
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(stored, treeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	body := fmt.Sprintf(`{"sha1s":["%s","%s"]}`, stored, missing)
+	res, err := http.Post(server.URL+"/bulk/get", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var parsed struct {
+		Results map[string]json.RawMessage `json:"results"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(parsed.Results[stored]) != treeURL {
+		t.Errorf("result for '%s' was '%s' instead of '%s'", stored, parsed.Results[stored], treeURL)
+	}
+	if string(parsed.Results[missing]) != "null" {
+		t.Errorf("result for '%s' was '%s' instead of null", missing, parsed.Results[missing])
+	}
+}
+
+// failingBatchDB wraps a MockDB with a UpsertTreeURLsBatch that always
+// fails, so bulkPut's handling of that error can be tested in isolation.
+// The real rollback behavior of db.PostgresDB.UpsertTreeURLsBatch's
+// sql.Tx is covered by TestUpsertTreeURLsBatchRollsBackOnFailure in
+// db/postgres_test.go.
+type failingBatchDB struct {
+	*MockDB
+}
+
+func (f *failingBatchDB) UpsertTreeURLsBatch(treeURLs map[string]string) error {
+	return fmt.Errorf("simulated batch failure")
+}
+
+func TestBulkPutHandlerReturns500OnBatchFailure(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+
+	mock := &failingBatchDB{MockDB: NewMockDB()}
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	body := fmt.Sprintf(`{"%s":[{"label":"a"}]}`, sha1)
+	res, err := http.Post(server.URL+"/bulk/put", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
+	}
+
+	has, err := mock.HasSHA1(sha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("tree URLs were stored despite the batch failing")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// checkpointAlgorithmEd25519 is the algorithm identifier byte prefixed to
+// every signature, the same signed-note convention sigsum checkpoints use.
+const checkpointAlgorithmEd25519 byte = 0x01
+
+// envelope is the signed response returned for a GET once signing is
+// enabled, binding a tree URL payload to a timestamp and a key identity.
+type envelope struct {
+	SHA1      string          `json:"sha1"`
+	TreeURLs  json.RawMessage `json:"tree_urls"`
+	Timestamp int64           `json:"timestamp"`
+	Signature string          `json:"signature"`
+	KeyID     string          `json:"key_id"`
+}
+
+// loadSigningKey reads an Ed25519 private key in PKCS8 PEM form from path.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in '%s'", path)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key in '%s' is not an Ed25519 private key", path)
+	}
+
+	return priv, nil
+}
+
+// keyID returns the hex-encoded SHA256 digest of pub, letting clients
+// identify which key produced a signature.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkpointMessage builds the canonical bytes a checkpoint signature
+// covers: the SHA1, the payload, and the timestamp, newline-separated.
+func checkpointMessage(sha1 string, treeURLs []byte, timestamp int64) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%d", sha1, treeURLs, timestamp))
+}
+
+// signCheckpoint signs message with priv, returning a base64 string of the
+// algorithm byte followed by the raw Ed25519 signature.
+func signCheckpoint(priv ed25519.PrivateKey, message []byte) string {
+	sig := ed25519.Sign(priv, message)
+	signed := append([]byte{checkpointAlgorithmEd25519}, sig...)
+	return base64.StdEncoding.EncodeToString(signed)
+}
+
+// verifyCheckpoint checks that signature, in the format signCheckpoint
+// produces, was made by pub over message.
+func verifyCheckpoint(pub ed25519.PublicKey, message []byte, signature string) (bool, error) {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+	if len(decoded) != 1+ed25519.SignatureSize {
+		return false, fmt.Errorf("signature is %d bytes, expected %d", len(decoded), 1+ed25519.SignatureSize)
+	}
+	if decoded[0] != checkpointAlgorithmEd25519 {
+		return false, fmt.Errorf("unsupported signature algorithm byte 0x%02x", decoded[0])
+	}
+	return ed25519.Verify(pub, message, decoded[1:]), nil
+}
+
+// WithSigningKey enables signed GET responses using priv, returning t for
+// chaining off of New or NewSecondary.
+func (t *TreeURLs) WithSigningKey(priv ed25519.PrivateKey) *TreeURLs {
+	t.signingKey = priv
+	return t
+}
+
+// envelopeFor builds and signs the envelope for a GET of sha1/treeURLs.
+func (t *TreeURLs) envelopeFor(sha1, treeURLs string) envelope {
+	timestamp := time.Now().Unix()
+	message := checkpointMessage(sha1, []byte(treeURLs), timestamp)
+	return envelope{
+		SHA1:      sha1,
+		TreeURLs:  json.RawMessage(treeURLs),
+		Timestamp: timestamp,
+		Signature: signCheckpoint(t.signingKey, message),
+		KeyID:     keyID(t.signingKey.Public().(ed25519.PublicKey)),
+	}
+}
+
+func (t *TreeURLs) pubkey(w http.ResponseWriter, r *http.Request) {
+	if t.signingKey == nil {
+		notFound(w, "signing is not enabled on this node")
+		return
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(t.signingKey.Public())
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func (t *TreeURLs) verifyEnvelope(w http.ResponseWriter, r *http.Request) {
+	if t.signingKey == nil {
+		notFound(w, "signing is not enabled on this node")
+		return
+	}
+
+	var env envelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	pub := t.signingKey.Public().(ed25519.PublicKey)
+	message := checkpointMessage(env.SHA1, env.TreeURLs, env.Timestamp)
+
+	ok, err := verifyCheckpoint(pub, message, env.Signature)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":  ok && env.KeyID == keyID(pub),
+		"key_id": keyID(pub),
+	})
+}
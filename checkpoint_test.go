@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestSigningKey(t *testing.T, priv ed25519.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestGetReturnsSignedEnvelope(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeTestSigningKey(t, priv)
+
+	loaded, err := loadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("error loading signing key: %s", err)
+	}
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(sha1, treeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock).WithSigningKey(loaded)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(bodyBytes, &env); err != nil {
+		t.Fatalf("error unmarshaling envelope: %s", err)
+	}
+
+	if env.SHA1 != sha1 {
+		t.Errorf("envelope sha1 was '%s' instead of '%s'", env.SHA1, sha1)
+	}
+	if string(env.TreeURLs) != treeURL {
+		t.Errorf("envelope tree_urls was '%s' instead of '%s'", env.TreeURLs, treeURL)
+	}
+
+	expectedKeyID := keyID(pub)
+	if env.KeyID != expectedKeyID {
+		t.Errorf("envelope key_id was '%s' instead of '%s'", env.KeyID, expectedKeyID)
+	}
+
+	message := checkpointMessage(env.SHA1, env.TreeURLs, env.Timestamp)
+	sigBytes, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		t.Fatalf("error decoding signature: %s", err)
+	}
+	if sigBytes[0] != checkpointAlgorithmEd25519 {
+		t.Errorf("algorithm byte was 0x%02x instead of 0x%02x", sigBytes[0], checkpointAlgorithmEd25519)
+	}
+	if !ed25519.Verify(pub, message, sigBytes[1:]) {
+		t.Error("signature did not verify against the public key")
+	}
+
+	// tree_urls must be embedded as a raw JSON value, the same wire shape
+	// the unsigned GET path returns, not JSON-escaped into a string.
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(bodyBytes, &raw); err != nil {
+		t.Fatalf("error unmarshaling envelope as raw JSON: %s", err)
+	}
+	if string(raw["tree_urls"]) != treeURL {
+		t.Errorf("tree_urls was embedded as '%s' instead of the raw payload '%s'", raw["tree_urls"], treeURL)
+	}
+}
+
+// TestGetReturnsSignedEnvelopeWithAmpersand confirms a tree_urls payload
+// containing '&' is neither HTML-escaped on the wire nor signed/verified
+// inconsistently with what's sent: json.Encoder HTML-escapes by default,
+// which would rewrite '&' to '&' in the embedded tree_urls value
+// without touching the signature computed over the unescaped payload.
+func TestGetReturnsSignedEnvelopeWithAmpersand(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view?a=1&b=2"}]`
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeTestSigningKey(t, priv)
+
+	loaded, err := loadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("error loading signing key: %s", err)
+	}
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(sha1, treeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock).WithSigningKey(loaded)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(bodyBytes, &raw); err != nil {
+		t.Fatalf("error unmarshaling envelope as raw JSON: %s", err)
+	}
+	if string(raw["tree_urls"]) != treeURL {
+		t.Errorf("tree_urls was embedded as '%s' instead of the raw payload '%s'", raw["tree_urls"], treeURL)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(bodyBytes, &env); err != nil {
+		t.Fatalf("error unmarshaling envelope: %s", err)
+	}
+	message := checkpointMessage(env.SHA1, env.TreeURLs, env.Timestamp)
+	sigBytes, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		t.Fatalf("error decoding signature: %s", err)
+	}
+	if !ed25519.Verify(pub, message, sigBytes[1:]) {
+		t.Error("signature did not verify against the public key for a tree_urls payload containing '&'")
+	}
+}
+
+func TestVerifyEnvelope(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(sha1, treeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock).WithSigningKey(priv)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	envelopeBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyRes, err := http.Post(server.URL+"/verify", "application/json", strings.NewReader(string(envelopeBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verifyRes.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(verifyRes.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if valid, _ := result["valid"].(bool); !valid {
+		t.Errorf("expected envelope to be reported valid, got: %+v", result)
+	}
+}
+
+func TestPubkeyNotEnabled(t *testing.T) {
+	mock := NewMockDB()
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/pubkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusNotFound)
+	}
+}
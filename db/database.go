@@ -0,0 +1,36 @@
+// Package db defines the storage interface tree-urls uses to read and
+// write tree URL records, along with its Postgres-backed implementation.
+package db
+
+//go:generate mockgen -destination=mocks/mock_database.go -package=mocks github.com/cyverse-de/tree-urls/db Database
+
+// Record is one row of the tree_urls table, as exposed over the internal
+// replication endpoints.
+type Record struct {
+	SHA1     string `json:"sha1"`
+	TreeURLs string `json:"tree_urls"`
+	Version  int64  `json:"version"`
+}
+
+// Database is the set of operations tree-urls needs from its storage
+// layer. It is satisfied by PostgresDB in production and by a
+// gomock-generated mock in tests.
+type Database interface {
+	HasSHA1(sha1 string) (bool, error)
+	GetTreeURLs(sha1 string) ([]string, error)
+	InsertTreeURLs(sha1, treeURLs string) error
+	UpdateTreeURLs(sha1, treeURLs string) error
+	DeleteTreeURLs(sha1 string) error
+
+	// GetSince, UpsertWithVersion, and HeadVersion back primary/secondary
+	// replication (see replication.go in the main package).
+	GetSince(version int64) ([]Record, error)
+	UpsertWithVersion(sha1, treeURLs string, version int64) error
+	HeadVersion() (int64, error)
+
+	// GetTreeURLsBatch and UpsertTreeURLsBatch back the bulk HTTP endpoints
+	// (see bulk.go in the main package). UpsertTreeURLsBatch applies every
+	// entry atomically: if any row fails, none of them are applied.
+	GetTreeURLsBatch(sha1s []string) (map[string]string, error)
+	UpsertTreeURLsBatch(treeURLs map[string]string) error
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var dbOpsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "tree_urls_db_ops_total",
+		Help: "Count of database operations, by operation and result.",
+	},
+	[]string{"op", "result"},
+)
+
+// recordOp increments dbOpsTotal for op, labeling the result ok or err
+// based on whether err is nil.
+func recordOp(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "err"
+	}
+	dbOpsTotal.WithLabelValues(op, result).Inc()
+}
+
+// PostgresDB is the Postgres-backed implementation of Database.
+type PostgresDB struct {
+	db *sql.DB
+}
+
+// NewPostgresDB returns a PostgresDB that queries db.
+func NewPostgresDB(db *sql.DB) *PostgresDB {
+	return &PostgresDB{db: db}
+}
+
+func (p *PostgresDB) HasSHA1(sha1 string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRow("SELECT EXISTS(SELECT 1 FROM tree_urls WHERE sha1 = $1)", sha1).Scan(&exists)
+	recordOp("HasSHA1", err)
+	return exists, err
+}
+
+func (p *PostgresDB) GetTreeURLs(sha1 string) ([]string, error) {
+	rows, err := p.db.Query("SELECT tree_urls FROM tree_urls WHERE sha1 = $1", sha1)
+	if err != nil {
+		recordOp("GetTreeURLs", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []string
+	for rows.Next() {
+		var treeURLs string
+		if err := rows.Scan(&treeURLs); err != nil {
+			recordOp("GetTreeURLs", err)
+			return nil, err
+		}
+		records = append(records, treeURLs)
+	}
+	err = rows.Err()
+	recordOp("GetTreeURLs", err)
+	return records, err
+}
+
+// nextVersionSQL computes the next monotonic version for a row, so every
+// write is visible to GetSince/HeadVersion and therefore to replication.
+const nextVersionSQL = "COALESCE((SELECT MAX(version) FROM tree_urls), 0) + 1"
+
+func (p *PostgresDB) InsertTreeURLs(sha1, treeURLs string) error {
+	_, err := p.db.Exec(
+		"INSERT INTO tree_urls (sha1, tree_urls, version) VALUES ($1, $2, "+nextVersionSQL+")",
+		sha1, treeURLs,
+	)
+	recordOp("InsertTreeURLs", err)
+	return err
+}
+
+func (p *PostgresDB) UpdateTreeURLs(sha1, treeURLs string) error {
+	_, err := p.db.Exec(
+		"UPDATE ONLY tree_urls SET tree_urls = $2, version = "+nextVersionSQL+" WHERE sha1 = $1",
+		sha1, treeURLs,
+	)
+	recordOp("UpdateTreeURLs", err)
+	return err
+}
+
+func (p *PostgresDB) DeleteTreeURLs(sha1 string) error {
+	_, err := p.db.Exec("DELETE FROM tree_urls WHERE sha1 = $1", sha1)
+	recordOp("DeleteTreeURLs", err)
+	return err
+}
+
+func (p *PostgresDB) GetSince(version int64) ([]Record, error) {
+	rows, err := p.db.Query(
+		"SELECT sha1, tree_urls, version FROM tree_urls WHERE version > $1 ORDER BY version",
+		version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.SHA1, &rec.TreeURLs, &rec.Version); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (p *PostgresDB) UpsertWithVersion(sha1, treeURLs string, version int64) error {
+	_, err := p.db.Exec(
+		`INSERT INTO tree_urls (sha1, tree_urls, version) VALUES ($1, $2, $3)
+		 ON CONFLICT (sha1) DO UPDATE SET tree_urls = $2, version = $3
+		 WHERE tree_urls.version < $3`,
+		sha1, treeURLs, version,
+	)
+	return err
+}
+
+func (p *PostgresDB) HeadVersion() (int64, error) {
+	var version sql.NullInt64
+	err := p.db.QueryRow("SELECT MAX(version) FROM tree_urls").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
+
+// GetTreeURLsBatch returns the tree URLs stored for each of sha1s in a
+// single query. sha1s with no stored record are omitted from the result.
+func (p *PostgresDB) GetTreeURLsBatch(sha1s []string) (map[string]string, error) {
+	rows, err := p.db.Query(
+		"SELECT sha1, tree_urls FROM tree_urls WHERE sha1 = ANY($1)",
+		pq.Array(sha1s),
+	)
+	if err != nil {
+		recordOp("GetTreeURLsBatch", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[string]string)
+	for rows.Next() {
+		var sha1, treeURLs string
+		if err := rows.Scan(&sha1, &treeURLs); err != nil {
+			recordOp("GetTreeURLsBatch", err)
+			return nil, err
+		}
+		results[sha1] = treeURLs
+	}
+	err = rows.Err()
+	recordOp("GetTreeURLsBatch", err)
+	return results, err
+}
+
+// UpsertTreeURLsBatch inserts or updates every sha1/tree URLs pair in
+// treeURLs inside a single transaction, rolling back all of them if any
+// one fails.
+func (p *PostgresDB) UpsertTreeURLsBatch(treeURLs map[string]string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		recordOp("UpsertTreeURLsBatch", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT INTO tree_urls (sha1, tree_urls, version) VALUES ($1, $2, ` + nextVersionSQL + `)
+		 ON CONFLICT (sha1) DO UPDATE SET tree_urls = $2, version = ` + nextVersionSQL,
+	)
+	if err != nil {
+		recordOp("UpsertTreeURLsBatch", err)
+		return err
+	}
+	defer stmt.Close()
+
+	for sha1, urls := range treeURLs {
+		if _, err := stmt.Exec(sha1, urls); err != nil {
+			recordOp("UpsertTreeURLsBatch", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	recordOp("UpsertTreeURLsBatch", err)
+	return err
+}
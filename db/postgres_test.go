@@ -0,0 +1,251 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNewPostgresDB(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Errorf("error from NewPostgresDB(): %s", err)
+	}
+
+	if p.db != mockDB {
+		t.Error("dbs did not match")
+	}
+}
+
+func TestHasSHA1(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Error("NewPostgresDB() returned nil")
+	}
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM tree_urls WHERE sha1 =").
+		WithArgs("sha1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	hasSHA1, err := p.HasSHA1("sha1")
+	if err != nil {
+		t.Errorf("error from HasSHA1(): %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+
+	if !hasSHA1 {
+		t.Error("HasSHA1() returned false")
+	}
+}
+
+func TestGetTreeURLs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Error("NewPostgresDB returned nil")
+	}
+
+	mock.ExpectQuery("SELECT tree_urls FROM tree_urls WHERE sha1 =").
+		WithArgs("sha1").
+		WillReturnRows(sqlmock.NewRows([]string{"tree_urls"}).AddRow("{}"))
+
+	records, err := p.GetTreeURLs("sha1")
+	if err != nil {
+		t.Errorf("error from GetTreeURLs(): %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+
+	if len(records) != 1 {
+		t.Errorf("number of records returned was %d instead of 1", len(records))
+	}
+
+	treeurl := records[0]
+
+	if treeurl != "{}" {
+		t.Errorf("tree url was %s instead of '{}'", treeurl)
+	}
+}
+
+func TestInsertTreeURLs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Error("NewPostgresDB returned nil")
+	}
+
+	mock.ExpectExec("INSERT INTO tree_urls \\(sha1, tree_urls, version\\) VALUES").
+		WithArgs("sha1", "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = p.InsertTreeURLs("sha1", "{}"); err != nil {
+		t.Errorf("error inserting tree urls: %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+}
+
+// TestInsertTreeURLsBumpsVersion confirms InsertTreeURLs assigns a version
+// on every insert, so HeadVersion/GetSince see writes made through the
+// ordinary PUT/POST path, not just replicateOnce's UpsertWithVersion.
+func TestInsertTreeURLsBumpsVersion(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+
+	mock.ExpectExec("INSERT INTO tree_urls \\(sha1, tree_urls, version\\) VALUES \\(\\$1, \\$2, COALESCE").
+		WithArgs("sha1", "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = p.InsertTreeURLs("sha1", "{}"); err != nil {
+		t.Errorf("error inserting tree urls: %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+}
+
+func TestUpdateTreeURLs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Error("NewPostgresDB returned nil")
+	}
+
+	mock.ExpectExec("UPDATE ONLY tree_urls SET tree_urls =").
+		WithArgs("sha1", "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = p.UpdateTreeURLs("sha1", "{}"); err != nil {
+		t.Errorf("error updating tree urls: %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+}
+
+// TestUpdateTreeURLsBumpsVersion confirms UpdateTreeURLs assigns a new
+// version on every update, so a second write to an already-replicated
+// SHA1 can be replicated again instead of being stuck at its old version.
+func TestUpdateTreeURLsBumpsVersion(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+
+	mock.ExpectExec("UPDATE ONLY tree_urls SET tree_urls = \\$2, version = COALESCE").
+		WithArgs("sha1", "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = p.UpdateTreeURLs("sha1", "{}"); err != nil {
+		t.Errorf("error updating tree urls: %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+}
+
+func TestDeleteTreeURLs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+
+	p := NewPostgresDB(mockDB)
+	if p == nil {
+		t.Error("NewPostgresDB() returned nil")
+	}
+
+	mock.ExpectExec("DELETE FROM tree_urls WHERE sha1 =").
+		WithArgs("sha1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = p.DeleteTreeURLs("sha1"); err != nil {
+		t.Errorf("error deleting tree urls: %s", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met: %s", err)
+	}
+}
+
+// TestUpsertTreeURLsBatchRollsBackOnFailure drives the real sql.Tx path in
+// UpsertTreeURLsBatch: of N rows in the batch, one fails, and the whole
+// transaction must roll back rather than commit the rows that succeeded.
+// Expectation order is disabled since map iteration order is unspecified;
+// the first exec is made to succeed and the second to fail, so whichever
+// row sqlmock sees first consumes the first expectation.
+func TestUpsertTreeURLsBatchRollsBackOnFailure(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating the mock db: %s", err)
+	}
+	defer mockDB.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	p := NewPostgresDB(mockDB)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO tree_urls")
+	mock.ExpectExec("INSERT INTO tree_urls").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO tree_urls").WillReturnError(fmt.Errorf("simulated row failure"))
+	mock.ExpectRollback()
+
+	err = p.UpsertTreeURLsBatch(map[string]string{
+		"sha1a": "{}",
+		"sha1b": "{}",
+	})
+	if err == nil {
+		t.Fatal("expected an error from a failing row in the batch")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expectations were not met (the transaction did not roll back as expected): %s", err)
+	}
+}
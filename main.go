@@ -0,0 +1,272 @@
+// Command tree-urls serves a small lookup table mapping the SHA1 of a tree
+// structure to the list of URLs describing it. Records are addressed by
+// their SHA1 and read or written with plain GET/PUT/POST/DELETE requests.
+package main
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/cyverse-de/tree-urls/db"
+	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+var sha1Regexp = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// TreeURLs is the HTTP front-end for a db.Database. It can run as a
+// primary, which accepts writes, or a secondary, which only serves reads
+// and replicates from a primary's internal endpoints.
+type TreeURLs struct {
+	db         db.Database
+	role       role
+	primaryURL string
+
+	// signingKey is non-nil once WithSigningKey has been called, which
+	// switches GET responses over to signed envelopes (see checkpoint.go).
+	signingKey ed25519.PrivateKey
+
+	// log is non-nil once WithMerkleLog has been called, which appends a
+	// leaf to the audit log for every successful write (see merkle.go).
+	log merkleLog
+
+	router   *mux.Router
+	internal *http.ServeMux
+}
+
+// New returns a primary TreeURLs backed by db. This is the configuration
+// tree-urls has always run in, and the one most tests exercise.
+func New(database db.Database) *TreeURLs {
+	t := &TreeURLs{
+		db:   database,
+		role: rolePrimary,
+	}
+	t.router = t.newRouter()
+	t.internal = t.newInternalMux()
+	return t
+}
+
+func (t *TreeURLs) newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", instrument("greeting", t.greeting)).Methods(http.MethodGet)
+	// /pubkey and /verify are registered ahead of /{sha1} so they aren't
+	// shadowed by it.
+	r.HandleFunc("/pubkey", instrument("pubkey", t.pubkey)).Methods(http.MethodGet)
+	r.HandleFunc("/verify", instrument("verify", t.verifyEnvelope)).Methods(http.MethodPost)
+	r.HandleFunc("/bulk/get", instrument("bulk_get", t.bulkGet)).Methods(http.MethodPost)
+	r.HandleFunc("/bulk/put", instrument("bulk_put", t.secondaryGuard(t.bulkPut))).Methods(http.MethodPost)
+	r.HandleFunc("/{sha1}", instrument("get", t.get)).Methods(http.MethodGet)
+	r.HandleFunc("/{sha1}", instrument("put", t.secondaryGuard(t.put))).Methods(http.MethodPut)
+	r.HandleFunc("/{sha1}", instrument("post", t.secondaryGuard(t.post))).Methods(http.MethodPost)
+	r.HandleFunc("/{sha1}", instrument("delete", t.secondaryGuard(t.delete))).Methods(http.MethodDelete)
+	r.HandleFunc("/log/sth", instrument("log_sth", t.logSTH)).Methods(http.MethodGet)
+	r.HandleFunc("/log/inclusion", instrument("log_inclusion", t.logInclusion)).Methods(http.MethodGet)
+	r.HandleFunc("/log/consistency", instrument("log_consistency", t.logConsistency)).Methods(http.MethodGet)
+	return r
+}
+
+func badRequest(w http.ResponseWriter, msg string) {
+	http.Error(w, msg, http.StatusBadRequest)
+}
+
+func errored(w http.ResponseWriter, msg string) {
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+func notFound(w http.ResponseWriter, msg string) {
+	http.Error(w, msg, http.StatusNotFound)
+}
+
+// validSHA1 reports whether sha1 looks like a SHA1 hex digest.
+func validSHA1(sha1 string) (bool, error) {
+	return sha1Regexp.MatchString(sha1), nil
+}
+
+func (t *TreeURLs) greeting(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "Hello from tree-urls.")
+}
+
+func (t *TreeURLs) get(w http.ResponseWriter, r *http.Request) {
+	sha1 := mux.Vars(r)["sha1"]
+
+	valid, err := validSHA1(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	if !valid {
+		badRequest(w, fmt.Sprintf("'%s' is not a valid SHA1", sha1))
+		return
+	}
+
+	has, err := t.db.HasSHA1(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	if !has {
+		notFound(w, fmt.Sprintf("no tree URLs found for '%s'", sha1))
+		return
+	}
+
+	records, err := t.db.GetTreeURLs(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	if t.signingKey != nil {
+		w.Header().Set("Content-Type", "application/json")
+		// HTML-escaping must stay off: it would rewrite &, <, > in the
+		// embedded tree_urls JSON, changing the wire bytes out from under
+		// the signature computed over the unescaped payload.
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(t.envelopeFor(sha1, records[0]))
+		return
+	}
+
+	fmt.Fprint(w, records[0])
+}
+
+func (t *TreeURLs) put(w http.ResponseWriter, r *http.Request) {
+	t.upsert(w, r)
+}
+
+func (t *TreeURLs) post(w http.ResponseWriter, r *http.Request) {
+	t.upsert(w, r)
+}
+
+func (t *TreeURLs) upsert(w http.ResponseWriter, r *http.Request) {
+	sha1 := mux.Vars(r)["sha1"]
+
+	valid, err := validSHA1(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	if !valid {
+		badRequest(w, fmt.Sprintf("'%s' is not a valid SHA1", sha1))
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	treeURLs := string(bodyBytes)
+
+	has, err := t.db.HasSHA1(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	if has {
+		err = t.db.UpdateTreeURLs(sha1, treeURLs)
+	} else {
+		err = t.db.InsertTreeURLs(sha1, treeURLs)
+	}
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	t.appendLogLeaf(strings.ToLower(r.Method), sha1, treeURLs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"tree_urls": treeURLs})
+}
+
+func (t *TreeURLs) delete(w http.ResponseWriter, r *http.Request) {
+	sha1 := mux.Vars(r)["sha1"]
+
+	valid, err := validSHA1(sha1)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	if !valid {
+		badRequest(w, fmt.Sprintf("'%s' is not a valid SHA1", sha1))
+		return
+	}
+
+	if err := t.db.DeleteTreeURLs(sha1); err != nil {
+		errored(w, err.Error())
+		return
+	}
+	t.appendLogLeaf("delete", sha1, "")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// fixAddr ensures addr has a leading colon, so a bare port number can be
+// passed on the command line.
+func fixAddr(addr string) string {
+	if !strings.HasPrefix(addr, ":") {
+		return ":" + addr
+	}
+	return addr
+}
+
+func main() {
+	var (
+		addr                = flag.String("addr", ":60000", "The address to listen on")
+		dbURI               = flag.String("db", "", "The URI used to connect to the database")
+		roleFlag            = flag.String("role", "primary", "The replication role to run as: primary or secondary")
+		primaryURL          = flag.String("primary-url", "", "The base URL of the primary, required when -role=secondary")
+		replicationInterval = flag.Duration("replication-interval", defaultReplicationInterval, "How often a secondary polls the primary for updates")
+		adminAddr           = flag.String("admin-addr", "", "The address to serve internal replication endpoints on. Defaults to the same address as -addr")
+		signingKeyPath      = flag.String("signing-key", "", "Path to an Ed25519 private key (PKCS8 PEM). When set, GET responses are signed envelopes instead of raw bodies")
+	)
+	flag.Parse()
+
+	sqlDB, err := sql.Open("postgres", *dbURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	p := db.NewPostgresDB(sqlDB)
+
+	var t *TreeURLs
+	switch *roleFlag {
+	case "primary":
+		t = New(p).WithMerkleLog(NewPostgresMerkleLog(sqlDB))
+	case "secondary":
+		if *primaryURL == "" {
+			log.Fatal("-primary-url is required when -role=secondary")
+		}
+		t = NewSecondary(p, *primaryURL, *replicationInterval)
+	default:
+		log.Fatalf("unknown -role '%s', must be 'primary' or 'secondary'", *roleFlag)
+	}
+
+	if *signingKeyPath != "" {
+		signingKey, err := loadSigningKey(*signingKeyPath)
+		if err != nil {
+			log.Fatalf("error loading signing key: %s", err)
+		}
+		t = t.WithSigningKey(signingKey)
+	}
+
+	if *adminAddr != "" && fixAddr(*adminAddr) != fixAddr(*addr) {
+		go func() {
+			log.Fatal(http.ListenAndServe(fixAddr(*adminAddr), t.internal))
+		}()
+		log.Fatal(http.ListenAndServe(fixAddr(*addr), t.router))
+	}
+
+	mainMux := http.NewServeMux()
+	mainMux.Handle("/", t.router)
+	mainMux.Handle("/internal/", t.internal)
+	log.Fatal(http.ListenAndServe(fixAddr(*addr), mainMux))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,11 +10,19 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/tree-urls/db"
+	"github.com/cyverse-de/tree-urls/db/mocks"
+	"github.com/golang/mock/gomock"
 )
 
+// MockDB is a lightweight, stateful db.Database used by the integration-style
+// tests in this package and in replication_test.go, metric_test.go,
+// checkpoint_test.go, and merkle_test.go. Tests that need to assert on exact
+// calls or inject specific errors use the gomock-generated mock in
+// db/mocks instead; see TestGetHasSHA1Error and its neighbors below.
 type MockDB struct {
 	storage map[string]map[string]interface{}
+	version int64
 }
 
 func NewMockDB() *MockDB {
@@ -22,32 +31,79 @@ func NewMockDB() *MockDB {
 	}
 }
 
-func (m *MockDB) hasSHA1(sha1 string) (bool, error) {
+func (m *MockDB) HasSHA1(sha1 string) (bool, error) {
 	var ok bool
 	_, ok = m.storage[sha1]
 	return ok, nil
 
 }
 
-func (m *MockDB) getTreeURLs(sha1 string) ([]string, error) {
+func (m *MockDB) GetTreeURLs(sha1 string) ([]string, error) {
 	return []string{m.storage[sha1]["tree_urls"].(string)}, nil
 }
 
-func (m *MockDB) deleteTreeURLs(sha1 string) error {
+func (m *MockDB) DeleteTreeURLs(sha1 string) error {
 	delete(m.storage, sha1)
 	return nil
 }
 
-func (m *MockDB) insertTreeURLs(sha1, treeURLs string) error {
+func (m *MockDB) InsertTreeURLs(sha1, treeURLs string) error {
 	if _, ok := m.storage[sha1]["tree_urls"]; !ok {
 		m.storage[sha1] = make(map[string]interface{})
 	}
+	m.version++
 	m.storage[sha1]["tree_urls"] = treeURLs
+	m.storage[sha1]["version"] = m.version
 	return nil
 }
 
-func (m *MockDB) updateTreeURLs(sha1, treeURLs string) error {
-	return m.insertTreeURLs(sha1, treeURLs)
+func (m *MockDB) UpdateTreeURLs(sha1, treeURLs string) error {
+	return m.InsertTreeURLs(sha1, treeURLs)
+}
+
+func (m *MockDB) GetSince(version int64) ([]db.Record, error) {
+	var records []db.Record
+	for sha1, fields := range m.storage {
+		if v := fields["version"].(int64); v > version {
+			records = append(records, db.Record{SHA1: sha1, TreeURLs: fields["tree_urls"].(string), Version: v})
+		}
+	}
+	return records, nil
+}
+
+func (m *MockDB) UpsertWithVersion(sha1, treeURLs string, version int64) error {
+	if _, ok := m.storage[sha1]; !ok {
+		m.storage[sha1] = make(map[string]interface{})
+	}
+	m.storage[sha1]["tree_urls"] = treeURLs
+	m.storage[sha1]["version"] = version
+	if version > m.version {
+		m.version = version
+	}
+	return nil
+}
+
+func (m *MockDB) HeadVersion() (int64, error) {
+	return m.version, nil
+}
+
+func (m *MockDB) GetTreeURLsBatch(sha1s []string) (map[string]string, error) {
+	results := make(map[string]string)
+	for _, sha1 := range sha1s {
+		if fields, ok := m.storage[sha1]; ok {
+			results[sha1] = fields["tree_urls"].(string)
+		}
+	}
+	return results, nil
+}
+
+func (m *MockDB) UpsertTreeURLsBatch(treeURLs map[string]string) error {
+	for sha1, urls := range treeURLs {
+		if err := m.InsertTreeURLs(sha1, urls); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func TestBadRequest(t *testing.T) {
@@ -174,16 +230,24 @@ func TestGreeting(t *testing.T) {
 	}
 }
 
+// newMockDatabase returns a gomock-backed db.Database for tests that need
+// to assert on exact calls or inject specific errors, which the stateful
+// MockDB above can't express.
+func newMockDatabase(t *testing.T) (*mocks.MockDatabase, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	return mocks.NewMockDatabase(ctrl), ctrl
+}
+
 func TestGet(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 	expectedBody := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	if err := mock.insertTreeURLs(sha1, expectedBody); err != nil {
-		t.Error(err)
-	}
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(true, nil)
+	mockDB.EXPECT().GetTreeURLs(sha1).Return([]string{expectedBody}, nil)
 
-	n := New(mock)
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -213,12 +277,61 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetHasSHA1Error(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(false, sql.ErrConnDone)
+
+	n := New(mockDB)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1))
+	if err != nil {
+		t.Error(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestGetGetTreeURLsError(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(true, nil)
+	mockDB.EXPECT().GetTreeURLs(sha1).Return(nil, sql.ErrConnDone)
+
+	n := New(mockDB)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1))
+	if err != nil {
+		t.Error(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
 func TestPutInsert(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	n := New(mock)
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(false, nil)
+	mockDB.EXPECT().InsertTreeURLs(sha1, treeURL).Return(nil)
+
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -258,12 +371,12 @@ func TestPutUpdate(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	if err := mock.insertTreeURLs(sha1, treeURL); err != nil {
-		t.Error(err)
-	}
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(true, nil)
+	mockDB.EXPECT().UpdateTreeURLs(sha1, treeURL).Return(nil)
 
-	n := New(mock)
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -299,12 +412,71 @@ func TestPutUpdate(t *testing.T) {
 	}
 }
 
+func TestPutHasSHA1Error(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0"}]`
+
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(false, sql.ErrConnDone)
+
+	n := New(mockDB)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", server.URL, sha1), strings.NewReader(treeURL))
+	if err != nil {
+		t.Error(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestPutInsertError(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0"}]`
+
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(false, nil)
+	mockDB.EXPECT().InsertTreeURLs(sha1, treeURL).Return(sql.ErrConnDone)
+
+	n := New(mockDB)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", server.URL, sha1), strings.NewReader(treeURL))
+	if err != nil {
+		t.Error(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
 func TestPostInsert(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	n := New(mock)
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(false, nil)
+	mockDB.EXPECT().InsertTreeURLs(sha1, treeURL).Return(nil)
+
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -339,12 +511,12 @@ func TestPostUpdate(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	if err := mock.insertTreeURLs(sha1, treeURL); err != nil {
-		t.Error(err)
-	}
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().HasSHA1(sha1).Return(true, nil)
+	mockDB.EXPECT().UpdateTreeURLs(sha1, treeURL).Return(nil)
 
-	n := New(mock)
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -377,14 +549,12 @@ func TestPostUpdate(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
-	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
 
-	mock := NewMockDB()
-	if err := mock.insertTreeURLs(sha1, treeURL); err != nil {
-		t.Error(err)
-	}
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().DeleteTreeURLs(sha1).Return(nil)
 
-	n := New(mock)
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -420,9 +590,11 @@ func TestDelete(t *testing.T) {
 func TestDeleteUnstored(t *testing.T) {
 	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 
-	mock := NewMockDB()
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().DeleteTreeURLs(sha1).Return(nil)
 
-	n := New(mock)
+	n := New(mockDB)
 	server := httptest.NewServer(n.router)
 	defer server.Close()
 
@@ -455,176 +627,45 @@ func TestDeleteUnstored(t *testing.T) {
 	}
 }
 
-func TestFixAddrNoPrefix(t *testing.T) {
-	expected := ":70000"
-	actual := fixAddr("70000")
-	if actual != expected {
-		t.Fail()
-	}
-}
-
-func TestFixAddrWithPrefix(t *testing.T) {
-	expected := ":70000"
-	actual := fixAddr(":70000")
-	if actual != expected {
-		t.Fail()
-	}
-}
-
-func TestNewPostgresDB(t *testing.T) {
-	db, _, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
-
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Errorf("error from NewPostgresDB(): %s", err)
-	}
-
-	if p.db != db {
-		t.Error("dbs did not match")
-	}
-}
-
-func TestHasSHA1(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
-
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Error("NewPostgresDB() returned nil")
-	}
-
-	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM tree_urls WHERE sha1 =").
-		WithArgs("sha1").
-		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
-
-	hasSHA1, err := p.hasSHA1("sha1")
-	if err != nil {
-		t.Errorf("error from hasSHA1(): %s", err)
-	}
-
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("expectations were not met: %s", err)
-	}
-
-	if !hasSHA1 {
-		t.Error("hasSHA1() returned false")
-	}
-}
-
-func TestGetTreeURLs(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
+func TestDeleteError(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
 
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Error("NewPostgresDB returned nil")
-	}
+	mockDB, ctrl := newMockDatabase(t)
+	defer ctrl.Finish()
+	mockDB.EXPECT().DeleteTreeURLs(sha1).Return(sql.ErrConnDone)
 
-	mock.ExpectQuery("SELECT tree_urls FROM tree_urls WHERE sha1 =").
-		WithArgs("sha1").
-		WillReturnRows(sqlmock.NewRows([]string{"tree_urls"}).AddRow("{}"))
+	n := New(mockDB)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
 
-	records, err := p.getTreeURLs("sha1")
+	sha1URL := fmt.Sprintf("%s/%s", server.URL, sha1)
+	req, err := http.NewRequest(http.MethodDelete, sha1URL, nil)
 	if err != nil {
-		t.Errorf("error from getTreeURLs(): %s", err)
-	}
-
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("expectations were not met: %s", err)
-	}
-
-	if len(records) != 1 {
-		t.Errorf("number of records returned was %d instead of 1", len(records))
-	}
-
-	treeurl := records[0]
-
-	if treeurl != "{}" {
-		t.Errorf("tree url was %s instead of '{}'", treeurl)
+		t.Error(err)
 	}
-}
-
-func TestInsertTreeURLs(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
-
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Error("NewPostgresDB returned nil")
-	}
-
-	mock.ExpectExec("INSERT INTO tree_urls \\(sha1, tree_urls\\) VALUES").
-		WithArgs("sha1", "{}").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	if err = p.insertTreeURLs("sha1", "{}"); err != nil {
-		t.Errorf("error inserting tree urls: %s", err)
+		t.Error(err)
 	}
+	res.Body.Close()
 
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("expectations were not met: %s", err)
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusInternalServerError)
 	}
 }
 
-func TestUpdateTreeURLs(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
-
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Error("NewPostgresDB returned nil")
-	}
-
-	mock.ExpectExec("UPDATE ONLY tree_urls SET tree_urls =").
-		WithArgs("sha1", "{}").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	if err = p.updateTreeURLs("sha1", "{}"); err != nil {
-		t.Errorf("error updating tree urls: %s", err)
-	}
-
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("expectations were not met: %s", err)
+func TestFixAddrNoPrefix(t *testing.T) {
+	expected := ":70000"
+	actual := fixAddr("70000")
+	if actual != expected {
+		t.Fail()
 	}
 }
 
-func TestDeleteTreeURLs(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("error creating the mock db: %s", err)
-	}
-	defer db.Close()
-
-	p := NewPostgresDB(db)
-	if p == nil {
-		t.Error("NewPostgresDB() returned nil")
-	}
-
-	mock.ExpectExec("DELETE FROM tree_urls WHERE sha1 =").
-		WithArgs("sha1").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	if err = p.deleteTreeURLs("sha1"); err != nil {
-		t.Errorf("error deleting tree urls: %s", err)
-	}
-
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("expectations were not met: %s", err)
+func TestFixAddrWithPrefix(t *testing.T) {
+	expected := ":70000"
+	actual := fixAddr(":70000")
+	if actual != expected {
+		t.Fail()
 	}
 }
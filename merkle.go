@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// merkleLog is the storage a TreeURLs needs to maintain an append-only
+// Merkle log of mutations and answer proof queries about it. It is
+// satisfied by PostgresMerkleLog in production.
+type merkleLog interface {
+	// appendLeaf stores a new leaf for op/sha1/treeURLs with the given
+	// precomputed leaf hash and returns its 0-based index in the log.
+	appendLeaf(op, sha1, treeURLs string, hash []byte) (index int64, err error)
+
+	// leafHash returns the hash of the leaf at the given 0-based index.
+	leafHash(index int64) ([]byte, error)
+
+	// leafIndexForHash looks up the 0-based index of the leaf with the
+	// given hash, if one exists.
+	leafIndexForHash(hash []byte) (index int64, ok bool, err error)
+
+	// size returns the number of leaves appended so far.
+	size() (int64, error)
+
+	// cachedNode and cacheNode get and set the cached hash of the complete
+	// 2^level-leaf subtree starting at leaf index*2^level.
+	cachedNode(level uint, index int64) (hash []byte, ok bool, err error)
+	cacheNode(level uint, index int64, hash []byte) error
+}
+
+// WithMerkleLog enables the append-only audit log for every successful
+// write, returning t for chaining off of New or NewSecondary.
+func (t *TreeURLs) WithMerkleLog(l merkleLog) *TreeURLs {
+	t.log = l
+	return t
+}
+
+// leafHashFor computes the RFC 6962 leaf hash for a mutation: the 0x00
+// leaf prefix byte, then the operation, SHA1, and tree URLs payload.
+func leafHashFor(op, sha1, treeURLs string) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write([]byte(op))
+	h.Write([]byte(sha1))
+	h.Write([]byte(treeURLs))
+	return h.Sum(nil)
+}
+
+// internalNodeHash computes the RFC 6962 internal node hash: the 0x01
+// node prefix byte, then the left and right child hashes.
+func internalNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// emptyHash is MTH of the empty tree, as defined by RFC 6962.
+func emptyHash() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// largestPowerOfTwoLessThan returns the largest k = 2^i such that k < n.
+// n must be greater than 1.
+func largestPowerOfTwoLessThan(n int64) int64 {
+	k := int64(1)
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// log2 returns i such that n == 2^i. n must be a power of two.
+func log2(n int64) uint {
+	var level uint
+	for n > 1 {
+		n >>= 1
+		level++
+	}
+	return level
+}
+
+// completeSubtreeHash returns MTH of the size-leaf, power-of-two-aligned
+// subtree starting at leaf index lo, caching the result since a complete
+// subtree's hash never changes as the log grows.
+func (t *TreeURLs) completeSubtreeHash(lo, size int64) ([]byte, error) {
+	if size == 1 {
+		return t.log.leafHash(lo)
+	}
+
+	level := log2(size)
+	index := lo / size
+	if cached, ok, err := t.log.cachedNode(level, index); err != nil {
+		return nil, err
+	} else if ok {
+		return cached, nil
+	}
+
+	half := size / 2
+	left, err := t.completeSubtreeHash(lo, half)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.completeSubtreeHash(lo+half, half)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := internalNodeHash(left, right)
+	if err := t.log.cacheNode(level, index, hash); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// mth computes the Merkle Tree Hash (RFC 6962) of the n leaves starting at
+// absolute index lo.
+func (t *TreeURLs) mth(lo, n int64) ([]byte, error) {
+	switch {
+	case n == 0:
+		return emptyHash(), nil
+	case n == 1:
+		return t.log.leafHash(lo)
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	left, err := t.completeSubtreeHash(lo, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.mth(lo+k, n-k)
+	if err != nil {
+		return nil, err
+	}
+	return internalNodeHash(left, right), nil
+}
+
+// inclusionPath computes the RFC 6962 audit path proving that the leaf at
+// absolute index lo+m is included in the tree formed by the n leaves
+// starting at lo. The returned hashes are ordered bottom-up: the sibling
+// closest to the leaf comes first, the one closest to the root comes last.
+func (t *TreeURLs) inclusionPath(lo, m, n int64) ([][]byte, error) {
+	if n <= 1 {
+		return nil, nil
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	if m < k {
+		path, err := t.inclusionPath(lo, m, k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.mth(lo+k, n-k)
+		if err != nil {
+			return nil, err
+		}
+		return append(path, sibling), nil
+	}
+
+	path, err := t.inclusionPath(lo+k, m-k, n-k)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.completeSubtreeHash(lo, k)
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// consistencyProof computes the RFC 6962 proof that the tree of size n is
+// an append-only extension of the tree of size m.
+func (t *TreeURLs) consistencyProof(m, n int64) ([][]byte, error) {
+	if m == 0 || m == n {
+		return nil, nil
+	}
+	return t.subProof(m, 0, n, true)
+}
+
+// subProof implements RFC 6962's SUBPROOF(m, D[lo:lo+n], b).
+func (t *TreeURLs) subProof(m, lo, n int64, b bool) ([][]byte, error) {
+	if m == n {
+		if b {
+			return nil, nil
+		}
+		hash, err := t.mth(lo, n)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{hash}, nil
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	if m <= k {
+		sub, err := t.subProof(m, lo, k, b)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.mth(lo+k, n-k)
+		if err != nil {
+			return nil, err
+		}
+		return append(sub, sibling), nil
+	}
+
+	sub, err := t.subProof(m-k, lo+k, n-k, false)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.completeSubtreeHash(lo, k)
+	if err != nil {
+		return nil, err
+	}
+	return append(sub, sibling), nil
+}
+
+func hexEncodeAll(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for i, h := range hashes {
+		encoded[i] = hex.EncodeToString(h)
+	}
+	return encoded
+}
+
+// appendLogLeaf records a mutation in the Merkle log, if one is
+// configured. Errors are logged rather than returned, since a logging
+// failure shouldn't fail the write it's recording.
+func (t *TreeURLs) appendLogLeaf(op, sha1, treeURLs string) {
+	if t.log == nil {
+		return
+	}
+	hash := leafHashFor(op, sha1, treeURLs)
+	if _, err := t.log.appendLeaf(op, sha1, treeURLs, hash); err != nil {
+		log.Errorf("error appending to the merkle log: %s", err)
+	}
+}
+
+func (t *TreeURLs) logSTH(w http.ResponseWriter, r *http.Request) {
+	if t.log == nil {
+		notFound(w, "the merkle log is not enabled on this node")
+		return
+	}
+
+	size, err := t.log.size()
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	root, err := t.mth(0, size)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tree_size": size,
+		"root_hash": hex.EncodeToString(root),
+	})
+}
+
+func (t *TreeURLs) logInclusion(w http.ResponseWriter, r *http.Request) {
+	if t.log == nil {
+		notFound(w, "the merkle log is not enabled on this node")
+		return
+	}
+
+	hash, err := hex.DecodeString(r.URL.Query().Get("hash"))
+	if err != nil {
+		badRequest(w, "'hash' must be a hex-encoded leaf hash")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil {
+		badRequest(w, "'size' must be an integer tree size")
+		return
+	}
+
+	index, ok, err := t.log.leafIndexForHash(hash)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	if !ok {
+		notFound(w, "no leaf with that hash")
+		return
+	}
+	if index >= size {
+		badRequest(w, "leaf is not included in a tree of that size")
+		return
+	}
+
+	path, err := t.inclusionPath(0, index, size)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"leaf_index": index,
+		"tree_size":  size,
+		"audit_path": hexEncodeAll(path),
+	})
+}
+
+func (t *TreeURLs) logConsistency(w http.ResponseWriter, r *http.Request) {
+	if t.log == nil {
+		notFound(w, "the merkle log is not enabled on this node")
+		return
+	}
+
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		badRequest(w, "'from' must be an integer tree size")
+		return
+	}
+
+	to, err := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+	if err != nil {
+		badRequest(w, "'to' must be an integer tree size")
+		return
+	}
+
+	if from < 0 || from > to {
+		badRequest(w, "'from' must be between 0 and 'to'")
+		return
+	}
+
+	proof, err := t.consistencyProof(from, to)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"proof": hexEncodeAll(proof),
+	})
+}
+
+// PostgresMerkleLog is the Postgres-backed implementation of merkleLog,
+// storing leaves in tree_url_log and cached subtree hashes in log_nodes.
+type PostgresMerkleLog struct {
+	db *sql.DB
+}
+
+// NewPostgresMerkleLog returns a PostgresMerkleLog that queries db.
+func NewPostgresMerkleLog(db *sql.DB) *PostgresMerkleLog {
+	return &PostgresMerkleLog{db: db}
+}
+
+func (p *PostgresMerkleLog) appendLeaf(op, sha1, treeURLs string, hash []byte) (int64, error) {
+	var seq int64
+	err := p.db.QueryRow(
+		"INSERT INTO tree_url_log (op, sha1, tree_urls, leaf_hash) VALUES ($1, $2, $3, $4) RETURNING seq",
+		op, sha1, treeURLs, hash,
+	).Scan(&seq)
+	if err != nil {
+		return 0, err
+	}
+	// seq is a 1-based bigserial; leaves are addressed by 0-based index.
+	return seq - 1, nil
+}
+
+func (p *PostgresMerkleLog) leafHash(index int64) ([]byte, error) {
+	var hash []byte
+	err := p.db.QueryRow("SELECT leaf_hash FROM tree_url_log WHERE seq = $1", index+1).Scan(&hash)
+	return hash, err
+}
+
+func (p *PostgresMerkleLog) leafIndexForHash(hash []byte) (int64, bool, error) {
+	var seq int64
+	err := p.db.QueryRow("SELECT seq FROM tree_url_log WHERE leaf_hash = $1", hash).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return seq - 1, true, nil
+}
+
+func (p *PostgresMerkleLog) size() (int64, error) {
+	var n int64
+	err := p.db.QueryRow("SELECT COUNT(*) FROM tree_url_log").Scan(&n)
+	return n, err
+}
+
+func (p *PostgresMerkleLog) cachedNode(level uint, index int64) ([]byte, bool, error) {
+	var hash []byte
+	err := p.db.QueryRow(
+		"SELECT hash FROM log_nodes WHERE level = $1 AND idx = $2",
+		level, index,
+	).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return hash, true, nil
+}
+
+func (p *PostgresMerkleLog) cacheNode(level uint, index int64, hash []byte) error {
+	_, err := p.db.Exec(
+		"INSERT INTO log_nodes (level, idx, hash) VALUES ($1, $2, $3) ON CONFLICT (level, idx) DO NOTHING",
+		level, index, hash,
+	)
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type logLeaf struct {
+	op, sha1, treeURLs string
+	hash               []byte
+}
+
+// mockMerkleLog is a simple in-memory merkleLog used to test the proof
+// algorithms without a real Postgres instance.
+type mockMerkleLog struct {
+	leaves []logLeaf
+	nodes  map[[2]int64][]byte
+}
+
+func newMockMerkleLog() *mockMerkleLog {
+	return &mockMerkleLog{nodes: make(map[[2]int64][]byte)}
+}
+
+func (m *mockMerkleLog) appendLeaf(op, sha1, treeURLs string, hash []byte) (int64, error) {
+	m.leaves = append(m.leaves, logLeaf{op, sha1, treeURLs, hash})
+	return int64(len(m.leaves) - 1), nil
+}
+
+func (m *mockMerkleLog) leafHash(index int64) ([]byte, error) {
+	if index < 0 || index >= int64(len(m.leaves)) {
+		return nil, fmt.Errorf("no leaf at index %d", index)
+	}
+	return m.leaves[index].hash, nil
+}
+
+func (m *mockMerkleLog) leafIndexForHash(hash []byte) (int64, bool, error) {
+	for i, leaf := range m.leaves {
+		if bytes.Equal(leaf.hash, hash) {
+			return int64(i), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (m *mockMerkleLog) size() (int64, error) {
+	return int64(len(m.leaves)), nil
+}
+
+func (m *mockMerkleLog) cachedNode(level uint, index int64) ([]byte, bool, error) {
+	hash, ok := m.nodes[[2]int64{int64(level), index}]
+	return hash, ok, nil
+}
+
+func (m *mockMerkleLog) cacheNode(level uint, index int64, hash []byte) error {
+	m.nodes[[2]int64{int64(level), index}] = hash
+	return nil
+}
+
+// rootFromInclusionPath recomputes the root hash implied by an inclusion
+// proof, mirroring the recursive structure inclusionPath was built with.
+func rootFromInclusionPath(m, n int64, leafHash []byte, path [][]byte) []byte {
+	if n == 1 {
+		return leafHash
+	}
+	k := largestPowerOfTwoLessThan(n)
+	sibling := path[len(path)-1]
+	rest := path[:len(path)-1]
+	if m < k {
+		return internalNodeHash(rootFromInclusionPath(m, k, leafHash, rest), sibling)
+	}
+	return internalNodeHash(sibling, rootFromInclusionPath(m-k, n-k, leafHash, rest))
+}
+
+func TestLogInclusionProof(t *testing.T) {
+	const count = 7
+
+	mockLog := newMockMerkleLog()
+	n := New(NewMockDB()).WithMerkleLog(mockLog)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	for i := 0; i < count; i++ {
+		sha1 := fmt.Sprintf("%040d", i)
+		treeURL := fmt.Sprintf(`[{"label":"tree_%d"}]`, i)
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/"+sha1, strings.NewReader(treeURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sthRes, err := http.Get(server.URL + "/log/sth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sthRes.Body.Close()
+
+	var sth struct {
+		TreeSize int64  `json:"tree_size"`
+		RootHash string `json:"root_hash"`
+	}
+	if err := json.NewDecoder(sthRes.Body).Decode(&sth); err != nil {
+		t.Fatal(err)
+	}
+	if sth.TreeSize != count {
+		t.Fatalf("tree_size was %d instead of %d", sth.TreeSize, count)
+	}
+
+	const targetIndex = 3
+	leafHash, err := mockLog.leafHash(targetIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofRes, err := http.Get(fmt.Sprintf(
+		"%s/log/inclusion?hash=%s&size=%d",
+		server.URL, hex.EncodeToString(leafHash), sth.TreeSize,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proofRes.Body.Close()
+
+	var proof struct {
+		LeafIndex int64    `json:"leaf_index"`
+		TreeSize  int64    `json:"tree_size"`
+		AuditPath []string `json:"audit_path"`
+	}
+	if err := json.NewDecoder(proofRes.Body).Decode(&proof); err != nil {
+		t.Fatal(err)
+	}
+	if proof.LeafIndex != targetIndex {
+		t.Fatalf("leaf_index was %d instead of %d", proof.LeafIndex, targetIndex)
+	}
+
+	path := make([][]byte, len(proof.AuditPath))
+	for i, hexHash := range proof.AuditPath {
+		decoded, err := hex.DecodeString(hexHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path[i] = decoded
+	}
+
+	computedRoot := rootFromInclusionPath(proof.LeafIndex, proof.TreeSize, leafHash, path)
+	if hex.EncodeToString(computedRoot) != sth.RootHash {
+		t.Errorf("root recomputed from the inclusion proof was '%s' instead of '%s'",
+			hex.EncodeToString(computedRoot), sth.RootHash)
+	}
+}
+
+func TestLogConsistencyProof(t *testing.T) {
+	mockLog := newMockMerkleLog()
+	n := New(NewMockDB()).WithMerkleLog(mockLog)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		sha1 := fmt.Sprintf("%040d", i)
+		treeURL := fmt.Sprintf(`[{"label":"tree_%d"}]`, i)
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/"+sha1, strings.NewReader(treeURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/log/consistency?from=3&to=5", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		From  int64    `json:"from"`
+		To    int64    `json:"to"`
+		Proof []string `json:"proof"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.From != 3 || result.To != 5 {
+		t.Errorf("from/to were %d/%d instead of 3/5", result.From, result.To)
+	}
+	if len(result.Proof) == 0 {
+		t.Error("expected a non-empty consistency proof between different tree sizes")
+	}
+}
+
+func TestLogNotEnabled(t *testing.T) {
+	n := New(NewMockDB())
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/log/sth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var requestDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "tree_urls_request_duration_seconds",
+		Help: "Time spent handling an HTTP request, by endpoint and response status code.",
+	},
+	[]string{"endpoint", "status"},
+)
+
+// statusResponseWriter wraps an http.ResponseWriter to record the status
+// code a handler writes, since http.ResponseWriter doesn't expose it.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusResponseWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// instrument wraps next so that every call to it observes its duration and
+// final status code in requestDuration, labeled with endpoint.
+func instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next(wrapped, r)
+
+		requestDuration.
+			WithLabelValues(endpoint, strconv.Itoa(wrapped.status)).
+			Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestMetricsObservesGetRequests(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
+
+	mock := NewMockDB()
+	if err := mock.InsertTreeURLs(sha1, treeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(mock)
+	server := httptest.NewServer(n.router)
+	defer server.Close()
+
+	adminServer := httptest.NewServer(n.internal)
+	defer adminServer.Close()
+
+	if _, err := http.Get(fmt.Sprintf("%s/%s", server.URL, sha1)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(adminServer.URL + "/internal/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re := regexp.MustCompile(`tree_urls_request_duration_seconds_count\{endpoint="get",status="200"\} (\d+)`)
+	match := re.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("metrics response did not contain a sample for endpoint=get status=200:\n%s", body)
+	}
+
+	if string(match[1]) == "0" {
+		t.Error("histogram recorded zero samples for endpoint=get status=200")
+	}
+}
+
+// TestMetricsReachableThroughCombinedMux drives the same combined-listener
+// wiring main() uses when -admin-addr is unset or equals -addr, so a
+// regression that only registers /metrics at the top level of t.internal
+// (unreachable behind the "/internal/" prefix main() forwards) is caught
+// here instead of only in tests that hit n.internal directly.
+func TestMetricsReachableThroughCombinedMux(t *testing.T) {
+	mock := NewMockDB()
+	n := New(mock)
+
+	mainMux := http.NewServeMux()
+	mainMux.Handle("/", n.router)
+	mainMux.Handle("/internal/", n.internal)
+
+	server := httptest.NewServer(mainMux)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/internal/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status was %d instead of %d", res.StatusCode, http.StatusOK)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("tree_urls_request_duration_seconds")) {
+		t.Errorf("response through the combined mux did not look like a Prometheus scrape:\n%s", body)
+	}
+}
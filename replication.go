@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cyverse-de/tree-urls/db"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultReplicationInterval is used when -replication-interval is not set.
+const defaultReplicationInterval = 30 * time.Second
+
+// role distinguishes a primary, which accepts writes, from a secondary,
+// which is read-only and replicates from a primary.
+type role int
+
+const (
+	rolePrimary role = iota
+	roleSecondary
+)
+
+// NewSecondary returns a read-only TreeURLs that replicates from the
+// primary at primaryURL every interval. Callers are expected to start
+// replication with StartReplication once the returned TreeURLs is wired
+// up to a listener.
+func NewSecondary(database db.Database, primaryURL string, interval time.Duration) *TreeURLs {
+	t := &TreeURLs{
+		db:         database,
+		role:       roleSecondary,
+		primaryURL: primaryURL,
+	}
+	t.router = t.newRouter()
+	t.internal = t.newInternalMux()
+
+	if interval <= 0 {
+		interval = defaultReplicationInterval
+	}
+	go t.StartReplication(interval, nil)
+
+	return t
+}
+
+// newInternalMux builds the mux for the internal endpoints a primary uses
+// to let secondaries catch up. Secondaries serve it too, purely so the
+// admin listener has something to answer with, but secondaries have
+// nothing useful to dump.
+func (t *TreeURLs) newInternalMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal/dump", t.dump)
+	mux.HandleFunc("/internal/head", t.head)
+	mux.Handle("/internal/metrics", promhttp.Handler())
+	return mux
+}
+
+// dump streams every record with a version greater than the "since" query
+// parameter as newline-delimited JSON.
+func (t *TreeURLs) dump(w http.ResponseWriter, r *http.Request) {
+	var (
+		since int64
+		err   error
+	)
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			badRequest(w, fmt.Sprintf("invalid 'since' value '%s'", s))
+			return
+		}
+	}
+
+	records, err := t.db.GetSince(since)
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			log.Errorf("error streaming dump: %s", err)
+			return
+		}
+	}
+}
+
+// head returns the current maximum version known to this node.
+func (t *TreeURLs) head(w http.ResponseWriter, r *http.Request) {
+	version, err := t.db.HeadVersion()
+	if err != nil {
+		errored(w, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int64{"version": version})
+}
+
+// secondaryGuard is middleware that refuses write methods on a secondary,
+// redirecting the client to the primary instead.
+func (t *TreeURLs) secondaryGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t.role == roleSecondary {
+			switch r.Method {
+			case http.MethodPut, http.MethodPost, http.MethodDelete:
+				w.Header().Set("Location", t.primaryURL+r.URL.Path)
+				w.WriteHeader(http.StatusTemporaryRedirect)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// replicateOnce fetches every record the primary has added since our last
+// known version and applies it locally. It's exported as its own step so
+// tests can trigger a single replication tick deterministically instead of
+// waiting on a ticker.
+func (t *TreeURLs) replicateOnce() error {
+	since, err := t.db.HeadVersion()
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/internal/dump?since=%d", t.primaryURL, since))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned status %d for dump", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var rec db.Record
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		if err := t.db.UpsertWithVersion(rec.SHA1, rec.TreeURLs, rec.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartReplication runs replicateOnce every interval until stop is closed.
+// A nil stop channel means "run forever".
+func (t *TreeURLs) StartReplication(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.replicateOnce(); err != nil {
+				log.Errorf("error replicating from %s: %s", t.primaryURL, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newReplicationServer wires up a TreeURLs' router and internal mux the
+// same way main() does, so tests can drive both the public API and the
+// internal replication endpoints through a single httptest.Server.
+func newReplicationServer(t *TreeURLs) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/internal/", t.internal)
+	mux.Handle("/", t.router)
+	return httptest.NewServer(mux)
+}
+
+func newTestSecondary(primaryURL string) *TreeURLs {
+	t := &TreeURLs{
+		db:         NewMockDB(),
+		role:       roleSecondary,
+		primaryURL: primaryURL,
+	}
+	t.router = t.newRouter()
+	t.internal = t.newInternalMux()
+	return t
+}
+
+func TestReplicationPropagatesPut(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+	treeURL := `[{"label":"tree_0","url":"http://portnoy.iplantcollaborative.org/view/tree/3727f35cc7125567492cab69850f6473"}]`
+
+	primary := New(NewMockDB())
+	primaryServer := newReplicationServer(primary)
+	defer primaryServer.Close()
+
+	secondary := newTestSecondary(primaryServer.URL)
+	secondaryServer := newReplicationServer(secondary)
+	defer secondaryServer.Close()
+
+	putURL := fmt.Sprintf("%s/%s", primaryServer.URL, sha1)
+	req, err := http.NewRequest(http.MethodPut, putURL, strings.NewReader(treeURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := secondary.replicateOnce(); err != nil {
+		t.Fatalf("error replicating: %s", err)
+	}
+
+	getURL := fmt.Sprintf("%s/%s", secondaryServer.URL, sha1)
+	res, err := http.Get(getURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("secondary GET returned status %d", res.StatusCode)
+	}
+
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bodyBytes) != treeURL {
+		t.Errorf("secondary returned '%s' instead of '%s'", string(bodyBytes), treeURL)
+	}
+}
+
+func TestSecondaryRedirectsWrites(t *testing.T) {
+	sha1 := "60e3da2efd886074e28e44d48cc642f84c25b140"
+
+	primary := New(NewMockDB())
+	primaryServer := newReplicationServer(primary)
+	defer primaryServer.Close()
+
+	secondary := newTestSecondary(primaryServer.URL)
+	secondaryServer := newReplicationServer(secondary)
+	defer secondaryServer.Close()
+
+	httpClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s", secondaryServer.URL, sha1), strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("status was %d instead of %d", res.StatusCode, http.StatusTemporaryRedirect)
+	}
+
+	expectedLocation := fmt.Sprintf("%s/%s", primaryServer.URL, sha1)
+	if loc := res.Header.Get("Location"); loc != expectedLocation {
+		t.Errorf("Location header was '%s' instead of '%s'", loc, expectedLocation)
+	}
+}